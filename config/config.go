@@ -71,11 +71,12 @@ type Status struct {
 
 // Performance is the performance section of the config.
 type Performance struct {
-	TCPKeepAlive    bool   `toml:"tcp-keep-alive" json:"tcp-keep-alive"`
-	RetryLimit      int    `toml:"retry-limit" json:"retry-limit"`
-	JoinConcurrency int    `toml:"join-concurrency" json:"join-concurrency"`
-	CrossJoin       bool   `toml:"cross-join" json:"cross-join"`
-	StatsLease      string `toml:"stats-lease" json:"stats-lease"`
+	TCPKeepAlive     bool   `toml:"tcp-keep-alive" json:"tcp-keep-alive"`
+	RetryLimit       int    `toml:"retry-limit" json:"retry-limit"`
+	JoinConcurrency  int    `toml:"join-concurrency" json:"join-concurrency"`
+	CrossJoin        bool   `toml:"cross-join" json:"cross-join"`
+	StatsLease       string `toml:"stats-lease" json:"stats-lease"`
+	MaxResponseBytes uint64 `toml:"max-response-bytes" json:"max-response-bytes"`
 }
 
 // XProtocol is the XProtocol section of the config.
@@ -14,6 +14,7 @@
 package server
 
 import (
+	"github.com/pingcap/tidb/mysql"
 	"github.com/pingcap/tidb/util/arena"
 )
 
@@ -31,10 +32,25 @@ type ColumnInfo struct {
 	Type               uint8
 	DefaultValueLength uint64
 	DefaultValue       []byte
+	// Elems is the element list of an enum or set column, it is not sent to the client.
+	Elems []string
 }
 
 // Dump dumps ColumnInfo to bytes.
 func (column *ColumnInfo) Dump(alloc arena.Allocator) []byte {
+	return column.DumpWithResultCharset(alloc, 0)
+}
+
+// DumpWithResultCharset dumps ColumnInfo to bytes, reporting resultCharset, the
+// character_set_results of the connection, instead of the column charset like MySQL.
+// A resultCharset of 0 means character_set_results is NULL, and binary columns
+// always keep the binary charset.
+func (column *ColumnInfo) DumpWithResultCharset(alloc arena.Allocator, resultCharset uint16) []byte {
+	charset := column.Charset
+	if resultCharset != 0 && charset != mysql.BinaryCollationID {
+		charset = resultCharset
+	}
+
 	l := len(column.Schema) + len(column.Table) + len(column.OrgTable) + len(column.Name) + len(column.OrgName) + len(column.DefaultValue) + 48
 
 	data := make([]byte, 0, l)
@@ -51,7 +67,7 @@ func (column *ColumnInfo) Dump(alloc arena.Allocator) []byte {
 
 	data = append(data, 0x0c)
 
-	data = append(data, dumpUint16(column.Charset)...)
+	data = append(data, dumpUint16(charset)...)
 	data = append(data, dumpUint32(column.ColumnLength)...)
 	data = append(data, column.Type)
 	data = append(data, dumpUint16(column.Flag)...)
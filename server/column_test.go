@@ -0,0 +1,65 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/arena"
+)
+
+type ColumnTestSuite struct{}
+
+var _ = Suite(ColumnTestSuite{})
+
+func (ts ColumnTestSuite) TestDumpOrgTableAndName(c *C) {
+	c.Parallel()
+	// A view column references the view by Table/Name and the base table by OrgTable/OrgName.
+	column := &ColumnInfo{
+		Schema:       "test",
+		Table:        "v",
+		OrgTable:     "t",
+		Name:         "va",
+		OrgName:      "a",
+		ColumnLength: 11,
+		Charset:      uint16(mysql.CharsetIDs["binary"]),
+		Type:         mysql.TypeLong,
+	}
+	fields, _ := parseColumnDef(c, column.Dump(arena.StdAllocator))
+	c.Assert(fields, DeepEquals, []string{"def", "test", "v", "t", "va", "a"})
+}
+
+func (ts ColumnTestSuite) TestDumpWithResultCharset(c *C) {
+	c.Parallel()
+	charsetOf := func(data []byte) uint16 {
+		_, rest := parseColumnDef(c, data)
+		// Skip the length of the fixed-length fields.
+		return uint16(rest[1]) | uint16(rest[2])<<8
+	}
+	utf8mb4 := uint16(mysql.CharsetIDs["utf8mb4"])
+	latin1 := uint16(mysql.CharsetIDs["latin1"])
+	binary := uint16(mysql.CharsetIDs["binary"])
+
+	column := &ColumnInfo{
+		Name:    "a",
+		Charset: utf8mb4,
+		Type:    mysql.TypeVarString,
+	}
+	c.Assert(charsetOf(column.Dump(arena.StdAllocator)), Equals, utf8mb4)
+	c.Assert(charsetOf(column.DumpWithResultCharset(arena.StdAllocator, 0)), Equals, utf8mb4)
+	c.Assert(charsetOf(column.DumpWithResultCharset(arena.StdAllocator, latin1)), Equals, latin1)
+
+	column.Charset = binary
+	c.Assert(charsetOf(column.DumpWithResultCharset(arena.StdAllocator, latin1)), Equals, binary)
+}
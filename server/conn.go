@@ -75,6 +75,8 @@ type clientConn struct {
 	ctx          QueryCtx          // an interface to execute sql statements.
 	attrs        map[string]string // attributes parsed from client handshake response, not used for now.
 	killed       bool
+	// responseBytes is the size of the rows sent for the current command, it is limited by max-response-bytes.
+	responseBytes uint64
 }
 
 func (cc *clientConn) String() string {
@@ -178,6 +180,82 @@ func (cc *clientConn) writePacket(data []byte) error {
 	return cc.pkt.writePacket(data)
 }
 
+// handshakeV10 is the initial handshake packet sent by the server, see writeInitialHandshake.
+type handshakeV10 struct {
+	ProtocolVersion uint8
+	ServerVersion   string
+	ConnectionID    uint32
+	Salt            []byte
+	Capability      uint32
+	Collation       uint8
+	Status          uint16
+	AuthPlugin      string
+}
+
+// parseHandshakeV10 parses the initial handshake packet on the client side.
+// See https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::HandshakeV10
+func parseHandshakeV10(data []byte) (*handshakeV10, error) {
+	if len(data) < 1 || data[0] != 10 {
+		return nil, mysql.ErrMalformPacket
+	}
+	packet := &handshakeV10{ProtocolVersion: data[0]}
+	offset := 1
+	// server version[00]
+	idx := bytes.IndexByte(data[offset:], 0)
+	if idx < 0 {
+		return nil, mysql.ErrMalformPacket
+	}
+	packet.ServerVersion = string(data[offset : offset+idx])
+	offset += idx + 1
+	// connection id, auth-plugin-data-part-1, filler, capability flag lower 2 bytes
+	if len(data) < offset+4+8+1+2 {
+		return nil, mysql.ErrMalformPacket
+	}
+	packet.ConnectionID = binary.LittleEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	packet.Salt = append(packet.Salt, data[offset:offset+8]...)
+	offset += 8 + 1
+	packet.Capability = uint32(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if len(data) == offset {
+		return packet, nil
+	}
+
+	// charset, status, capability flag upper 2 bytes, length of auth-plugin-data, reserved 10 [00]
+	if len(data) < offset+1+2+2+1+10 {
+		return nil, mysql.ErrMalformPacket
+	}
+	packet.Collation = data[offset]
+	offset++
+	packet.Status = binary.LittleEndian.Uint16(data[offset : offset+2])
+	offset += 2
+	packet.Capability |= uint32(binary.LittleEndian.Uint16(data[offset:offset+2])) << 16
+	offset += 2
+	authLen := int(data[offset])
+	offset += 1 + 10
+
+	if packet.Capability&mysql.ClientSecureConnection > 0 {
+		// auth-plugin-data-part-2 is at least 13 bytes and ends with [00].
+		part2Len := authLen - 8
+		if part2Len < 13 {
+			part2Len = 13
+		}
+		if len(data) < offset+part2Len {
+			return nil, mysql.ErrMalformPacket
+		}
+		packet.Salt = append(packet.Salt, data[offset:offset+part2Len-1]...)
+		offset += part2Len
+	}
+	if packet.Capability&mysql.ClientPluginAuth > 0 {
+		idx = bytes.IndexByte(data[offset:], 0)
+		if idx < 0 {
+			idx = len(data) - offset
+		}
+		packet.AuthPlugin = string(data[offset : offset+idx])
+	}
+	return packet, nil
+}
+
 type handshakeResponse41 struct {
 	Capability uint32
 	Collation  uint8
@@ -489,9 +567,13 @@ func (cc *clientConn) addMetrics(cmd byte, startTime time.Time, err error) {
 // It also gets a token from server which is used to limit the concurrently handling clients.
 // The most frequently used command is ComQuery.
 func (cc *clientConn) dispatch(data []byte) error {
+	if len(data) == 0 {
+		return mysql.ErrMalformPacket
+	}
 	cmd := data[0]
 	data = data[1:]
 	cc.lastCmd = hack.String(data)
+	cc.responseBytes = 0
 	token := cc.server.getToken()
 	defer func() {
 		cc.server.releaseToken(token)
@@ -513,10 +595,16 @@ func (cc *clientConn) dispatch(data []byte) error {
 		if len(data) > 0 && data[len(data)-1] == 0 {
 			data = data[:len(data)-1]
 		}
+		if len(data) == 0 {
+			return mysql.NewErr(mysql.ErrEmptyQuery)
+		}
 		return cc.handleQuery(hack.String(data))
 	case mysql.ComPing:
 		return cc.writeOK()
 	case mysql.ComInitDB:
+		if len(data) == 0 {
+			return mysql.NewErr(mysql.ErrNoDB)
+		}
 		if err := cc.useDB(hack.String(data)); err != nil {
 			return errors.Trace(err)
 		}
@@ -524,6 +612,9 @@ func (cc *clientConn) dispatch(data []byte) error {
 	case mysql.ComFieldList:
 		return cc.handleFieldList(hack.String(data))
 	case mysql.ComStmtPrepare:
+		if len(data) == 0 {
+			return mysql.NewErr(mysql.ErrEmptyQuery)
+		}
 		return cc.handleStmtPrepare(hack.String(data))
 	case mysql.ComStmtExecute:
 		return cc.handleStmtExecute(data)
@@ -540,6 +631,18 @@ func (cc *clientConn) dispatch(data []byte) error {
 	}
 }
 
+// parseProcessKill parses a legacy COM_PROCESS_KILL packet, the data includes the command byte.
+// See https://dev.mysql.com/doc/internals/en/com-process-kill.html
+func parseProcessKill(data []byte) (connID uint32, err error) {
+	if len(data) != 5 {
+		return 0, mysql.ErrMalformPacket
+	}
+	if data[0] != mysql.ComProcessKill {
+		return 0, mysql.NewErrf(mysql.ErrUnknown, "unexpected command %d for process kill", data[0])
+	}
+	return binary.LittleEndian.Uint32(data[1:]), nil
+}
+
 func (cc *clientConn) useDB(db string) (err error) {
 	// if input is "use `SELECT`", mysql client just send "SELECT"
 	// so we add `` around db.
@@ -555,6 +658,8 @@ func (cc *clientConn) flush() error {
 	return cc.pkt.flush()
 }
 
+// writeOK writes an OK packet, the last insert id is the one of the first row
+// for a multi-row INSERT, see QueryCtx.LastInsertID.
 func (cc *clientConn) writeOK() error {
 	data := cc.alloc.AllocWithLen(4, 32)
 	data = append(data, mysql.OKHeader)
@@ -573,25 +678,26 @@ func (cc *clientConn) writeOK() error {
 	return errors.Trace(cc.flush())
 }
 
-func (cc *clientConn) writeError(e error) error {
-	var (
-		m  *mysql.SQLError
-		te *terror.Error
-		ok bool
-	)
+// toSQLError converts an error to the MySQL error sent to the client.
+func toSQLError(e error) *mysql.SQLError {
 	originErr := errors.Cause(e)
-	if te, ok = originErr.(*terror.Error); ok {
-		m = te.ToSQLError()
-	} else {
-		m = mysql.NewErrf(mysql.ErrUnknown, "%s", e.Error())
+	if te, ok := originErr.(*terror.Error); ok {
+		return te.ToSQLError()
 	}
+	if m, ok := originErr.(*mysql.SQLError); ok {
+		return m
+	}
+	return mysql.NewErrf(mysql.ErrUnknown, "%s", e.Error())
+}
 
+func (cc *clientConn) writeError(e error) error {
+	m := toSQLError(e)
 	data := cc.alloc.AllocWithLen(4, 16+len(m.Message))
 	data = append(data, mysql.ErrHeader)
 	data = append(data, byte(m.Code), byte(m.Code>>8))
 	if cc.capability&mysql.ClientProtocol41 > 0 {
 		data = append(data, '#')
-		data = append(data, m.State...)
+		data = append(data, sqlStateForPacket(m.State)...)
 	}
 
 	data = append(data, m.Message...)
@@ -603,6 +709,15 @@ func (cc *clientConn) writeError(e error) error {
 	return errors.Trace(cc.flush())
 }
 
+// sqlStateForPacket makes sure the SQL state sent in an ERR packet has exactly 5 characters,
+// an invalid state, for example a vendor-specific one, is replaced by the default state.
+func sqlStateForPacket(state string) string {
+	if len(state) != 5 {
+		return mysql.DefaultMySQLState
+	}
+	return state
+}
+
 // writeEOF writes an EOF packet.
 // Note this function won't flush the stream because maybe there are more
 // packets following it, the "more" argument would indicates that case.
@@ -618,7 +733,7 @@ func (cc *clientConn) writeEOF(more bool) error {
 		if more {
 			status |= mysql.ServerMoreResultsExists
 		}
-		data = append(data, dumpUint16(cc.ctx.Status())...)
+		data = append(data, dumpUint16(status)...)
 	}
 
 	err := cc.writePacket(data)
@@ -751,15 +866,20 @@ func (cc *clientConn) handleQuery(sql string) (err error) {
 // handleFieldList returns the field list for a table.
 // The sql string is composed of a table name and a terminating character \x00.
 func (cc *clientConn) handleFieldList(sql string) (err error) {
+	// The table name is a null-terminated string, the field wildcard follows it.
 	parts := strings.Split(sql, "\x00")
+	if len(parts) < 2 || len(parts[0]) == 0 {
+		return mysql.ErrMalformPacket
+	}
 	columns, err := cc.ctx.FieldList(parts[0])
 	if err != nil {
 		return errors.Trace(err)
 	}
+	resultCharset := cc.ctx.ResultCharset()
 	data := make([]byte, 4, 1024)
 	for _, v := range columns {
 		data = data[0:4]
-		data = append(data, v.Dump(cc.alloc)...)
+		data = append(data, v.DumpWithResultCharset(cc.alloc, resultCharset)...)
 		if err := cc.writePacket(data); err != nil {
 			return errors.Trace(err)
 		}
@@ -795,9 +915,10 @@ func (cc *clientConn) writeResultset(rs ResultSet, binary bool, more bool) error
 		return errors.Trace(err)
 	}
 
+	resultCharset := cc.ctx.ResultCharset()
 	for _, v := range columns {
 		data = data[0:4]
-		data = append(data, v.Dump(cc.alloc)...)
+		data = append(data, v.DumpWithResultCharset(cc.alloc, resultCharset)...)
 		if err = cc.writePacket(data); err != nil {
 			return errors.Trace(err)
 		}
@@ -807,6 +928,7 @@ func (cc *clientConn) writeResultset(rs ResultSet, binary bool, more bool) error
 		return errors.Trace(err)
 	}
 
+	maxResponseBytes := cc.server.cfg.Performance.MaxResponseBytes
 	for {
 		if err != nil {
 			return errors.Trace(err)
@@ -817,7 +939,7 @@ func (cc *clientConn) writeResultset(rs ResultSet, binary bool, more bool) error
 		data = data[0:4]
 		if binary {
 			var rowData []byte
-			rowData, err = dumpRowValuesBinary(cc.alloc, columns, row)
+			rowData, err = dumpRowValuesBinary(cc.alloc, columns, row, 0)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -837,6 +959,14 @@ func (cc *clientConn) writeResultset(rs ResultSet, binary bool, more bool) error
 			}
 		}
 
+		// Send the rows within the limit to the client before reporting the error.
+		cc.responseBytes += uint64(len(data) - 4)
+		if maxResponseBytes > 0 && cc.responseBytes > maxResponseBytes {
+			if err = cc.flush(); err != nil {
+				return errors.Trace(err)
+			}
+			return errResponseTooLarge.Gen("response exceeds max-response-bytes %d", maxResponseBytes)
+		}
 		if err = cc.writePacket(data); err != nil {
 			return errors.Trace(err)
 		}
@@ -84,9 +84,10 @@ func (cc *clientConn) handleStmtPrepare(sql string) error {
 	}
 
 	if len(columns) > 0 {
+		resultCharset := cc.ctx.ResultCharset()
 		for i := 0; i < len(columns); i++ {
 			data = data[0:4]
-			data = append(data, columns[i].Dump(cc.alloc)...)
+			data = append(data, columns[i].DumpWithResultCharset(cc.alloc, resultCharset)...)
 
 			if err := cc.writePacket(data); err != nil {
 				return errors.Trace(err)
@@ -18,8 +18,13 @@ import (
 	"bytes"
 	"encoding/binary"
 
+	"github.com/juju/errors"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/terror"
+	"github.com/pingcap/tidb/util/arena"
+	"github.com/pingcap/tidb/util/types"
 )
 
 type ConnTestSuite struct{}
@@ -149,6 +154,272 @@ func (ts ConnTestSuite) TestInitialHandshake(c *C) {
 	c.Assert(outBuffer.Bytes()[4:], DeepEquals, expected.Bytes())
 }
 
+func (ts ConnTestSuite) TestParseHandshakeV10(c *C) {
+	c.Parallel()
+	var outBuffer bytes.Buffer
+	salt := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11, 0x12, 0x13, 0x14}
+	cc := &clientConn{
+		connectionID: 42,
+		salt:         salt,
+		server: &Server{
+			capability: defaultCapability,
+		},
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(&outBuffer),
+		},
+	}
+	err := cc.writeInitialHandshake()
+	c.Assert(err, IsNil)
+
+	data := outBuffer.Bytes()[4:]
+	p, err := parseHandshakeV10(data)
+	c.Assert(err, IsNil)
+	c.Assert(p, DeepEquals, &handshakeV10{
+		ProtocolVersion: 10,
+		ServerVersion:   mysql.ServerVersion,
+		ConnectionID:    42,
+		Salt:            salt,
+		Capability:      defaultCapability,
+		Collation:       uint8(mysql.DefaultCollationID),
+		Status:          mysql.ServerStatusAutocommit,
+		AuthPlugin:      "mysql_native_password",
+	})
+
+	_, err = parseHandshakeV10(data[:20])
+	c.Assert(err, Equals, mysql.ErrMalformPacket)
+	_, err = parseHandshakeV10(append([]byte{9}, data[1:]...))
+	c.Assert(err, Equals, mysql.ErrMalformPacket)
+}
+
+// mockQueryCtx reports a fixed status and result charset, the other methods are not implemented.
+type mockQueryCtx struct {
+	QueryCtx
+	status        uint16
+	resultCharset uint16
+}
+
+func (qc *mockQueryCtx) Status() uint16 {
+	return qc.status
+}
+
+func (qc *mockQueryCtx) AffectedRows() uint64 {
+	return 0
+}
+
+func (qc *mockQueryCtx) LastInsertID() uint64 {
+	return 0
+}
+
+func (qc *mockQueryCtx) WarningCount() uint16 {
+	return 0
+}
+
+func (qc *mockQueryCtx) ResultCharset() uint16 {
+	return qc.resultCharset
+}
+
+func (ts ConnTestSuite) TestWriteStatus(c *C) {
+	c.Parallel()
+	var outBuffer bytes.Buffer
+	status := mysql.ServerStatusInTrans | mysql.ServerStatusAutocommit
+	cc := &clientConn{
+		alloc:      arena.NewAllocator(1024),
+		capability: mysql.ClientProtocol41,
+		ctx:        &mockQueryCtx{status: status},
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(&outBuffer),
+		},
+	}
+	// The OK and EOF packets carry the status of the session.
+	c.Assert(cc.writeOK(), IsNil)
+	c.Assert(cc.writeEOF(false), IsNil)
+	c.Assert(cc.writeEOF(true), IsNil)
+	c.Assert(cc.flush(), IsNil)
+
+	packets := splitPackets(outBuffer.Bytes())
+	c.Assert(packets, HasLen, 3)
+	c.Assert(packets[0], DeepEquals, []byte{mysql.OKHeader, 0, 0, byte(status), byte(status >> 8), 0, 0})
+	c.Assert(packets[1], DeepEquals, []byte{mysql.EOFHeader, 0, 0, byte(status), byte(status >> 8)})
+	more := status | mysql.ServerMoreResultsExists
+	c.Assert(packets[2], DeepEquals, []byte{mysql.EOFHeader, 0, 0, byte(more), byte(more >> 8)})
+}
+
+func (ts ConnTestSuite) TestWriteError(c *C) {
+	c.Parallel()
+	var outBuffer bytes.Buffer
+	cc := &clientConn{
+		capability: mysql.ClientProtocol41,
+		alloc:      arena.NewAllocator(1024),
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(&outBuffer),
+		},
+	}
+	// A *mysql.SQLError keeps its code and message, any other error is sent as ER_UNKNOWN_ERROR.
+	// A SQL state that does not have 5 characters is replaced by the default state.
+	tests := []struct {
+		err      error
+		code     uint16
+		expected string
+	}{
+		{&mysql.SQLError{Code: mysql.ErrUnknown, Message: "msg", State: "42S02"}, mysql.ErrUnknown, "#42S02msg"},
+		{&mysql.SQLError{Code: mysql.ErrUnknown, Message: "msg", State: "HY0"}, mysql.ErrUnknown, "#" + mysql.DefaultMySQLState + "msg"},
+		{&mysql.SQLError{Code: mysql.ErrUnknown, Message: "msg", State: "TIDB001"}, mysql.ErrUnknown, "#" + mysql.DefaultMySQLState + "msg"},
+		{mysql.ErrMalformPacket, mysql.ErrUnknown, "#HY000Malform packet error"},
+		{errors.Trace(mysql.ErrMalformPacket), mysql.ErrUnknown, "#HY000Malform packet error"},
+		{mysql.NewErr(mysql.ErrEmptyQuery), mysql.ErrEmptyQuery, "#42000Query was empty"},
+		{errors.Trace(mysql.NewErr(mysql.ErrEmptyQuery)), mysql.ErrEmptyQuery, "#42000Query was empty"},
+	}
+	for _, t := range tests {
+		outBuffer.Reset()
+		cc.pkt.sequence = 0
+		c.Assert(cc.writeError(t.err), IsNil)
+		data := outBuffer.Bytes()[4:]
+		c.Assert(data[0], Equals, mysql.ErrHeader)
+		c.Assert(binary.LittleEndian.Uint16(data[1:3]), Equals, t.code)
+		c.Assert(string(data[3:]), Equals, t.expected)
+	}
+}
+
+func (ts ConnTestSuite) TestParseProcessKill(c *C) {
+	c.Parallel()
+	connID, err := parseProcessKill([]byte{mysql.ComProcessKill, 0x78, 0x56, 0x34, 0x12})
+	c.Assert(err, IsNil)
+	c.Assert(connID, Equals, uint32(0x12345678))
+
+	_, err = parseProcessKill([]byte{mysql.ComProcessKill, 0x01, 0x00})
+	c.Assert(err, Equals, mysql.ErrMalformPacket)
+	_, err = parseProcessKill([]byte{mysql.ComProcessKill, 0x01, 0x00, 0x00, 0x00, 0x00})
+	c.Assert(err, Equals, mysql.ErrMalformPacket)
+	_, err = parseProcessKill([]byte{mysql.ComQuery, 0x01, 0x00, 0x00, 0x00})
+	c.Assert(err, NotNil)
+}
+
+type mockResultSet struct {
+	columns []*ColumnInfo
+	rows    [][]types.Datum
+}
+
+func (rs *mockResultSet) Columns() ([]*ColumnInfo, error) {
+	return rs.columns, nil
+}
+
+func (rs *mockResultSet) Next() ([]types.Datum, error) {
+	if len(rs.rows) == 0 {
+		return nil, nil
+	}
+	row := rs.rows[0]
+	rs.rows = rs.rows[1:]
+	return row, nil
+}
+
+func (rs *mockResultSet) Close() error {
+	return nil
+}
+
+// splitPackets splits the data written by a packetIO into packet payloads.
+func splitPackets(data []byte) [][]byte {
+	var packets [][]byte
+	for len(data) > 0 {
+		length := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16)
+		packets = append(packets, data[4:4+length])
+		data = data[4+length:]
+	}
+	return packets
+}
+
+// parseColumnDef parses the catalog, schema, table, org_table, name and org_name
+// of a column definition, rest holds the fixed-length fields that follow them.
+func parseColumnDef(c *C, data []byte) (fields []string, rest []byte) {
+	for i := 0; i < 6; i++ {
+		v, isNull, n, err := parseLengthEncodedBytes(data)
+		c.Assert(err, IsNil)
+		c.Assert(isNull, IsFalse)
+		fields = append(fields, string(v))
+		data = data[n:]
+	}
+	return fields, data
+}
+
+func (ts ConnTestSuite) TestMaxResponseBytes(c *C) {
+	c.Parallel()
+	var outBuffer bytes.Buffer
+	cfg := &config.Config{}
+	// Each row packet has 11 bytes, so only two rows fit.
+	cfg.Performance.MaxResponseBytes = 25
+	cc := &clientConn{
+		alloc:  arena.NewAllocator(1024),
+		server: &Server{cfg: cfg},
+		ctx:    &mockQueryCtx{},
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(&outBuffer),
+		},
+	}
+	rs := &mockResultSet{
+		columns: []*ColumnInfo{{Name: "a", Type: mysql.TypeVarString}},
+	}
+	for i := 0; i < 3; i++ {
+		rs.rows = append(rs.rows, []types.Datum{types.NewStringDatum("0123456789")})
+	}
+	err := cc.writeResultset(rs, false, false)
+	c.Assert(terror.ErrorEqual(err, errResponseTooLarge), IsTrue)
+
+	// column count, column definition, EOF, then the two rows within the limit.
+	packets := splitPackets(outBuffer.Bytes())
+	c.Assert(packets, HasLen, 5)
+	c.Assert(packets[3], DeepEquals, append([]byte{10}, "0123456789"...))
+	c.Assert(packets[4], DeepEquals, append([]byte{10}, "0123456789"...))
+
+	// The limit applies to all the result sets of a query, each of these fits alone.
+	outBuffer.Reset()
+	cc.responseBytes = 0
+	var rss []ResultSet
+	for i := 0; i < 2; i++ {
+		rs := &mockResultSet{
+			columns: []*ColumnInfo{{Name: "a", Type: mysql.TypeVarString}},
+		}
+		for j := 0; j < 2; j++ {
+			rs.rows = append(rs.rows, []types.Datum{types.NewStringDatum("0123456789")})
+		}
+		rss = append(rss, rs)
+	}
+	err = cc.writeMultiResultset(rss, false)
+	c.Assert(terror.ErrorEqual(err, errResponseTooLarge), IsTrue)
+
+	// The first result set with its EOF, then the second one stops before its first row.
+	packets = splitPackets(outBuffer.Bytes())
+	c.Assert(packets, HasLen, 9)
+}
+
+func (ts ConnTestSuite) TestWriteResultsetColumnOrder(c *C) {
+	c.Parallel()
+	var outBuffer bytes.Buffer
+	cc := &clientConn{
+		alloc:  arena.NewAllocator(1024),
+		server: &Server{cfg: &config.Config{}},
+		ctx:    &mockQueryCtx{},
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(&outBuffer),
+		},
+	}
+	// The column definitions must follow the order given by the result set.
+	names := []string{"c", "a", "b"}
+	rs := &mockResultSet{}
+	for _, name := range names {
+		rs.columns = append(rs.columns, &ColumnInfo{Name: name, Type: mysql.TypeVarString})
+	}
+	err := cc.writeResultset(rs, false, false)
+	c.Assert(err, IsNil)
+
+	// column count, column definitions, then EOFs.
+	packets := splitPackets(outBuffer.Bytes())
+	c.Assert(packets, HasLen, 1+len(names)+2)
+	c.Assert(packets[0], DeepEquals, []byte{byte(len(names))})
+	for i, name := range names {
+		fields, _ := parseColumnDef(c, packets[1+i])
+		c.Assert(fields[4], Equals, name)
+	}
+}
+
 func mapIdentical(m1, m2 map[string]string) bool {
 	return mapBelong(m1, m2) && mapBelong(m2, m1)
 }
@@ -34,6 +34,7 @@ type QueryCtx interface {
 	Status() uint16
 
 	// LastInsertID returns last inserted ID.
+	// For a multi-row INSERT, it is the ID of the first inserted row, the same as MySQL.
 	LastInsertID() uint64
 
 	// AffectedRows returns affected rows of last executed command.
@@ -57,6 +58,9 @@ type QueryCtx interface {
 	// CurrentDB returns current DB.
 	CurrentDB() string
 
+	// ResultCharset returns the collation ID of character_set_results, 0 if it is NULL.
+	ResultCharset() uint16
+
 	// Execute executes a SQL statement.
 	Execute(sql string) ([]ResultSet, error)
 
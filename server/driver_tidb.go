@@ -16,12 +16,14 @@ package server
 import (
 	"crypto/tls"
 	"fmt"
+	"strings"
 
 	"github.com/juju/errors"
 	"github.com/pingcap/tidb"
 	"github.com/pingcap/tidb/ast"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/util"
 	"github.com/pingcap/tidb/util/auth"
 	"github.com/pingcap/tidb/util/types"
@@ -184,6 +186,15 @@ func (tc *TiDBContext) CurrentDB() string {
 	return tc.currentDB
 }
 
+// ResultCharset implements QueryCtx ResultCharset method.
+func (tc *TiDBContext) ResultCharset() uint16 {
+	cs := tc.session.GetSessionVars().Systems[variable.CharacterSetResults]
+	if cs == "" {
+		return 0
+	}
+	return uint16(mysql.CharsetIDs[strings.ToLower(cs)])
+}
+
 // WarningCount implements QueryCtx WarningCount method.
 func (tc *TiDBContext) WarningCount() uint16 {
 	return tc.session.GetSessionVars().StmtCtx.WarningCount()
@@ -340,6 +351,7 @@ func convertColumnInfo(fld *ast.ResultField) (ci *ColumnInfo) {
 		ci.Decimal = uint8(fld.Column.Decimal)
 	}
 	ci.Type = uint8(fld.Column.Tp)
+	ci.Elems = fld.Column.Elems
 
 	// Keep things compatible for old clients.
 	// Refer to mysql-server/sql/protocol.cc send_result_set_metadata()
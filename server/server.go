@@ -60,6 +60,8 @@ var (
 	errInvalidPayloadLen = terror.ClassServer.New(codeInvalidPayloadLen, "invalid payload length")
 	errInvalidSequence   = terror.ClassServer.New(codeInvalidSequence, "invalid sequence")
 	errInvalidType       = terror.ClassServer.New(codeInvalidType, "invalid type")
+	errResponseTooLarge  = terror.ClassServer.New(codeResponseTooLarge, "response too large")
+	errEmptyColumnInfo   = terror.ClassServer.New(codeEmptyColumnInfo, "column info is empty")
 	errNotAllowedCommand = terror.ClassServer.New(codeNotAllowedCommand, "the used command is not allowed with this TiDB version")
 	errAccessDenied      = terror.ClassServer.New(codeAccessDenied, mysql.MySQLErrName[mysql.ErrAccessDenied])
 )
@@ -330,6 +332,8 @@ const (
 	codeInvalidPayloadLen = 2
 	codeInvalidSequence   = 3
 	codeInvalidType       = 4
+	codeResponseTooLarge  = 5
+	codeEmptyColumnInfo   = 6
 
 	codeNotAllowedCommand = 1148
 	codeAccessDenied      = mysql.ErrAccessDenied
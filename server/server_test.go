@@ -636,6 +636,25 @@ func runTestResultFieldTableIsNull(c *C) {
 	})
 }
 
+func runTestLastInsertID(c *C) {
+	runTestsOnNewDB(c, nil, "LastInsertID", func(dbt *DBTest) {
+		dbt.mustExec("create table test (id int auto_increment primary key, c int)")
+		res := dbt.mustExec("insert into test (c) values (1)")
+		id, err := res.LastInsertId()
+		dbt.Assert(err, IsNil)
+		dbt.Assert(id, Equals, int64(1))
+
+		// For a multi-row insert, MySQL reports the id of the first inserted row.
+		res = dbt.mustExec("insert into test (c) values (2), (3), (4)")
+		id, err = res.LastInsertId()
+		dbt.Assert(err, IsNil)
+		dbt.Assert(id, Equals, int64(2))
+		affected, err := res.RowsAffected()
+		dbt.Assert(err, IsNil)
+		dbt.Assert(affected, Equals, int64(3))
+	})
+}
+
 func runTestStatusAPI(c *C) {
 	resp, err := http.Get("http://127.0.0.1:10090/status")
 	c.Assert(err, IsNil)
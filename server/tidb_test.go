@@ -15,6 +15,8 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -31,6 +33,8 @@ import (
 	. "github.com/pingcap/check"
 	"github.com/pingcap/tidb"
 	"github.com/pingcap/tidb/config"
+	tmysql "github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/arena"
 )
 
 type TidbTestSuite struct {
@@ -133,6 +137,11 @@ func (ts *TidbTestSuite) TestResultFieldTableIsNull(c *C) {
 	runTestResultFieldTableIsNull(c)
 }
 
+func (ts *TidbTestSuite) TestLastInsertID(c *C) {
+	c.Parallel()
+	runTestLastInsertID(c)
+}
+
 func (ts *TidbTestSuite) TestStatusAPI(c *C) {
 	c.Parallel()
 	runTestStatusAPI(c)
@@ -371,3 +380,163 @@ func (ts *TidbTestSuite) TestClientWithCollation(c *C) {
 	c.Parallel()
 	runTestClientWithCollation(c)
 }
+
+// newTestClientConn creates a clientConn on a new session that writes to outBuffer,
+// dispatch clears outBuffer and the packet sequence before dispatching a command.
+func (ts *TidbTestSuite) newTestClientConn(c *C) (cc *clientConn, outBuffer *bytes.Buffer, dispatch func(cmd byte, data ...byte) error) {
+	ctx, err := ts.tidbdrv.OpenCtx(0, defaultCapability, tmysql.DefaultCollationID, "", nil)
+	c.Assert(err, IsNil)
+	outBuffer = new(bytes.Buffer)
+	cc = &clientConn{
+		server:     ts.server,
+		capability: defaultCapability,
+		alloc:      arena.NewAllocator(1024),
+		ctx:        ctx,
+		pkt: &packetIO{
+			bufWriter: bufio.NewWriter(outBuffer),
+		},
+	}
+	dispatch = func(cmd byte, data ...byte) error {
+		outBuffer.Reset()
+		cc.pkt.sequence = 0
+		return cc.dispatch(append([]byte{cmd}, data...))
+	}
+	return cc, outBuffer, dispatch
+}
+
+func (ts *TidbTestSuite) TestParseAllCommands(c *C) {
+	c.Parallel()
+	cc, outBuffer, dispatch := ts.newTestClientConn(c)
+	defer cc.ctx.Close()
+	errCode := func(err error) uint16 {
+		sqlErr, ok := errors.Cause(err).(*tmysql.SQLError)
+		c.Assert(ok, IsTrue, Commentf("%v", err))
+		return sqlErr.Code
+	}
+
+	// An empty packet has no command byte.
+	c.Assert(cc.dispatch(nil), Equals, tmysql.ErrMalformPacket)
+
+	c.Assert(dispatch(tmysql.ComQuery, []byte("create database if not exists test_commands")...), IsNil)
+	c.Assert(errCode(dispatch(tmysql.ComQuery)), Equals, uint16(tmysql.ErrEmptyQuery))
+	c.Assert(errCode(dispatch(tmysql.ComQuery, 0x00)), Equals, uint16(tmysql.ErrEmptyQuery))
+
+	c.Assert(dispatch(tmysql.ComInitDB, []byte("test_commands")...), IsNil)
+	c.Assert(errCode(dispatch(tmysql.ComInitDB)), Equals, uint16(tmysql.ErrNoDB))
+	c.Assert(dispatch(tmysql.ComQuery, []byte("create table if not exists t (a bigint)")...), IsNil)
+
+	c.Assert(dispatch(tmysql.ComFieldList, []byte("t\x00")...), IsNil)
+	c.Assert(dispatch(tmysql.ComFieldList), Equals, tmysql.ErrMalformPacket)
+	c.Assert(dispatch(tmysql.ComFieldList, []byte("t")...), Equals, tmysql.ErrMalformPacket)
+
+	c.Assert(errCode(dispatch(tmysql.ComStmtPrepare)), Equals, uint16(tmysql.ErrEmptyQuery))
+	c.Assert(dispatch(tmysql.ComStmtPrepare, []byte("select ?")...), IsNil)
+	// The first packet of the response is the OK header followed by the statement id.
+	stmtID := append([]byte{}, outBuffer.Bytes()[5:9]...)
+
+	execute := append([]byte{}, stmtID...)
+	execute = append(execute, 0x00, 0x01, 0x00, 0x00, 0x00) // flag, iteration count
+	execute = append(execute, 0x00, 0x01)                   // null bitmap, new params bound flag
+	execute = append(execute, tmysql.TypeLonglong, 0x00)    // param type
+	execute = append(execute, dumpUint64(1)...)             // param value
+	c.Assert(dispatch(tmysql.ComStmtExecute, execute...), IsNil)
+	c.Assert(dispatch(tmysql.ComStmtExecute, execute[:8]...), Equals, tmysql.ErrMalformPacket)
+
+	c.Assert(dispatch(tmysql.ComStmtSendLongData, stmtID[:2]...), Equals, tmysql.ErrMalformPacket)
+
+	c.Assert(dispatch(tmysql.ComStmtReset, stmtID...), IsNil)
+	c.Assert(dispatch(tmysql.ComStmtReset, stmtID[:2]...), Equals, tmysql.ErrMalformPacket)
+
+	c.Assert(dispatch(tmysql.ComSetOption, 0x00, 0x00), IsNil)
+	c.Assert(dispatch(tmysql.ComSetOption, 0x02, 0x00), Equals, tmysql.ErrMalformPacket)
+	c.Assert(dispatch(tmysql.ComSetOption, 0x00), Equals, tmysql.ErrMalformPacket)
+
+	// COM_PING has no payload, it always gets an OK packet.
+	c.Assert(dispatch(tmysql.ComPing), IsNil)
+	c.Assert(splitPackets(outBuffer.Bytes()), HasLen, 1)
+
+	// COM_STMT_CLOSE has no response, a truncated packet is ignored like MySQL does.
+	c.Assert(dispatch(tmysql.ComStmtClose, stmtID[:2]...), IsNil)
+	c.Assert(cc.flush(), IsNil)
+	c.Assert(outBuffer.Len(), Equals, 0)
+	c.Assert(dispatch(tmysql.ComStmtClose, stmtID...), IsNil)
+	c.Assert(cc.flush(), IsNil)
+	c.Assert(outBuffer.Len(), Equals, 0)
+	c.Assert(errCode(dispatch(tmysql.ComStmtExecute, execute...)), Equals, uint16(tmysql.ErrUnknownStmtHandler))
+
+	// COM_CHANGE_USER is not supported yet, it must fail cleanly.
+	changeUser := append([]byte("root\x00"), 0x00)
+	changeUser = append(changeUser, []byte("test_commands\x00")...)
+	c.Assert(errCode(dispatch(tmysql.ComChangeUser, changeUser...)), Equals, uint16(tmysql.ErrUnknown))
+
+	c.Assert(dispatch(tmysql.ComQuery, []byte("drop database test_commands")...), IsNil)
+}
+
+func (ts *TidbTestSuite) TestResultCharset(c *C) {
+	c.Parallel()
+	cc, outBuffer, dispatch := ts.newTestClientConn(c)
+	defer cc.ctx.Close()
+	query := func(sql string) {
+		c.Assert(dispatch(tmysql.ComQuery, []byte(sql)...), IsNil)
+	}
+	// columnCharsets returns the charset of each column definition of the result set.
+	columnCharsets := func() []uint16 {
+		packets := splitPackets(outBuffer.Bytes())
+		var charsets []uint16
+		for _, data := range packets[1 : 1+int(packets[0][0])] {
+			_, rest := parseColumnDef(c, data)
+			charsets = append(charsets, uint16(rest[1])|uint16(rest[2])<<8)
+		}
+		return charsets
+	}
+	utf8mb4 := uint16(tmysql.CharsetIDs["utf8mb4"])
+	latin1 := uint16(tmysql.CharsetIDs["latin1"])
+	binary := uint16(tmysql.CharsetIDs["binary"])
+
+	query("create database if not exists test_result_charset")
+	query("use test_result_charset")
+	query("create table if not exists t (a varchar(10) charset utf8mb4, b varbinary(10))")
+
+	// The columns are reported with character_set_results, except the binary ones.
+	query("set character_set_results = latin1")
+	query("select a, b from t")
+	c.Assert(columnCharsets(), DeepEquals, []uint16{latin1, binary})
+
+	// A NULL character_set_results keeps the charset of the columns.
+	query("set character_set_results = NULL")
+	query("select a, b from t")
+	c.Assert(columnCharsets(), DeepEquals, []uint16{utf8mb4, binary})
+
+	query("drop database test_result_charset")
+}
+
+func (ts *TidbTestSuite) TestSessionStatus(c *C) {
+	c.Parallel()
+	cc, outBuffer, dispatch := ts.newTestClientConn(c)
+	defer cc.ctx.Close()
+	// okStatus and eofStatus return the status flags of the OK packet of a statement
+	// and of the last EOF packet of a query, both without affected rows or last insert id.
+	okStatus := func(sql string) uint16 {
+		c.Assert(dispatch(tmysql.ComQuery, []byte(sql)...), IsNil)
+		packets := splitPackets(outBuffer.Bytes())
+		c.Assert(packets, HasLen, 1)
+		c.Assert(packets[0][0], Equals, byte(tmysql.OKHeader))
+		return uint16(packets[0][3]) | uint16(packets[0][4])<<8
+	}
+	eofStatus := func(sql string) uint16 {
+		c.Assert(dispatch(tmysql.ComQuery, []byte(sql)...), IsNil)
+		packets := splitPackets(outBuffer.Bytes())
+		eof := packets[len(packets)-1]
+		c.Assert(eof[0], Equals, byte(tmysql.EOFHeader))
+		return uint16(eof[3]) | uint16(eof[4])<<8
+	}
+
+	c.Assert(okStatus("begin"), Equals, tmysql.ServerStatusInTrans|tmysql.ServerStatusAutocommit)
+	c.Assert(eofStatus("select 1"), Equals, tmysql.ServerStatusInTrans|tmysql.ServerStatusAutocommit)
+	c.Assert(okStatus("commit"), Equals, tmysql.ServerStatusAutocommit)
+	c.Assert(eofStatus("select 1"), Equals, tmysql.ServerStatusAutocommit)
+
+	c.Assert(okStatus("set autocommit = 0")&tmysql.ServerStatusAutocommit, Equals, uint16(0))
+	c.Assert(eofStatus("select 1")&tmysql.ServerStatusAutocommit, Equals, uint16(0))
+	c.Assert(okStatus("set autocommit = 1")&tmysql.ServerStatusAutocommit, Equals, tmysql.ServerStatusAutocommit)
+}
@@ -35,6 +35,8 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
 	"math"
@@ -119,6 +121,8 @@ func parseLengthEncodedBytes(b []byte) ([]byte, bool, int, error) {
 	return nil, false, n, io.EOF
 }
 
+// dumpLengthEncodedString dumps b with a length-encoded integer prefix,
+// so b may contain null bytes, unlike a null-terminated string.
 func dumpLengthEncodedString(b []byte, alloc arena.Allocator) []byte {
 	data := alloc.Alloc(len(b) + 9)
 	data = append(data, dumpLengthEncodedInt(uint64(len(b)))...)
@@ -163,6 +167,8 @@ func init() {
 	}
 }
 
+// dumpBinaryTime dumps a TIME value in the binary protocol format. It does not round the value,
+// a TIME value is already rounded to the fsp of its column when it is created, the same as DATETIME.
 func dumpBinaryTime(dur time.Duration) (data []byte) {
 	if dur == 0 {
 		data = tinyIntCache[0]
@@ -194,16 +200,39 @@ func dumpBinaryTime(dur time.Duration) (data []byte) {
 	return
 }
 
-func dumpBinaryDateTime(t types.Time, loc *time.Location) (data []byte, err error) {
-	if t.Type == mysql.TypeTimestamp && loc != nil {
-		// TODO: Consider time_zone variable.
-		t1, err := t.Time.GoTime(time.Local)
-		if err != nil {
-			return nil, errors.Errorf("FATAL: convert timestamp %v go time return error!", t.Time)
-		}
-		t.Time = types.FromGoTime(t1.In(loc))
+// parseBinaryDuration parses a TIME value encoded by dumpBinaryTime,
+// it returns the duration and the number of bytes consumed.
+func parseBinaryDuration(b []byte) (dur time.Duration, n int, err error) {
+	if len(b) == 0 {
+		return 0, 0, mysql.ErrMalformPacket
+	}
+	n = int(b[0]) + 1
+	switch b[0] {
+	case 0:
+		return 0, n, nil
+	case 8, 12:
+	default:
+		return 0, 0, mysql.ErrMalformPacket
+	}
+	if len(b) < n {
+		return 0, 0, mysql.ErrMalformPacket
+	}
+	dur = time.Duration(binary.LittleEndian.Uint32(b[2:6]))*24*time.Hour +
+		time.Duration(b[6])*time.Hour +
+		time.Duration(b[7])*time.Minute +
+		time.Duration(b[8])*time.Second
+	if b[0] == 12 {
+		dur += time.Duration(binary.LittleEndian.Uint32(b[9:13])) * time.Microsecond
 	}
+	if b[1] == 1 {
+		dur = -dur
+	}
+	return dur, n, nil
+}
 
+// dumpBinaryDateTime dumps t as is, a TIMESTAMP is already in the session time zone,
+// it is converted when decoded from storage.
+func dumpBinaryDateTime(t types.Time) (data []byte, err error) {
 	year, mon, day := t.Time.Year(), t.Time.Month(), t.Time.Day()
 	if t.IsZero() {
 		year, mon, day = 1, int(time.January), 1
@@ -245,7 +274,17 @@ func uniformValue(value interface{}) interface{} {
 	}
 }
 
-func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []types.Datum) (data []byte, err error) {
+// binaryDumpFlag changes how dumpRowValuesBinary encodes values for
+// consumers other than the MySQL client, the MySQL client uses 0.
+type binaryDumpFlag uint8
+
+const (
+	// binaryDumpPackedDecimal dumps DECIMAL values in the packed binary format
+	// of the storage layer instead of the string format of the protocol.
+	binaryDumpPackedDecimal binaryDumpFlag = 1 << iota
+)
+
+func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []types.Datum, flag binaryDumpFlag) (data []byte, err error) {
 	if len(columns) != len(row) {
 		err = mysql.ErrMalformPacket
 		return
@@ -262,6 +301,9 @@ func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []typ
 	}
 	data = append(data, nulls...)
 	for i, val := range row {
+		if columns[i] == nil {
+			return nil, errors.Trace(errEmptyColumnInfo)
+		}
 		switch val.Kind() {
 		case types.KindInt64:
 			v := val.GetInt64()
@@ -287,18 +329,37 @@ func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []typ
 			case mysql.TypeLonglong:
 				data = append(data, dumpUint64(uint64(v))...)
 			}
-		case types.KindFloat32:
-			floatBits := math.Float32bits(val.GetFloat32())
-			data = append(data, dumpUint32(floatBits)...)
-		case types.KindFloat64:
-			floatBits := math.Float64bits(val.GetFloat64())
-			data = append(data, dumpUint64(floatBits)...)
+		case types.KindFloat32, types.KindFloat64:
+			// The width of a binary float is decided by the column type, any other
+			// column type means the executor produced a mismatched datum.
+			switch columns[i].Type {
+			case mysql.TypeFloat:
+				data = append(data, dumpUint32(math.Float32bits(float32(normalizeNegativeZero(val.GetFloat64()))))...)
+			case mysql.TypeDouble:
+				data = append(data, dumpUint64(math.Float64bits(normalizeNegativeZero(val.GetFloat64())))...)
+			default:
+				return data, errInvalidType.Gen("invalid type %v for column type %d", val.Kind(), columns[i].Type)
+			}
 		case types.KindString, types.KindBytes:
 			data = append(data, dumpLengthEncodedString(val.GetBytes(), alloc)...)
 		case types.KindMysqlDecimal:
-			data = append(data, dumpLengthEncodedString(hack.Slice(val.GetMysqlDecimal().String()), alloc)...)
+			if flag&binaryDumpPackedDecimal > 0 {
+				dec := val.GetMysqlDecimal()
+				precision, frac := int(columns[i].ColumnLength), int(columns[i].Decimal)
+				if precision == 0 || frac == mysql.NotFixedDec {
+					// An expression column has no declared precision and scale, use the ones of the value.
+					precision, frac = dec.PrecisionAndFrac()
+				}
+				bin, err := dec.ToBin(precision, frac)
+				if err != nil {
+					return data, errors.Trace(err)
+				}
+				data = append(data, dumpLengthEncodedString(bin, alloc)...)
+			} else {
+				data = append(data, dumpLengthEncodedString(hack.Slice(val.GetMysqlDecimal().String()), alloc)...)
+			}
 		case types.KindMysqlTime:
-			tmp, err := dumpBinaryDateTime(val.GetMysqlTime(), nil)
+			tmp, err := dumpBinaryDateTime(val.GetMysqlTime())
 			if err != nil {
 				return data, errors.Trace(err)
 			}
@@ -308,6 +369,9 @@ func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []typ
 		case types.KindMysqlSet:
 			data = append(data, dumpLengthEncodedString(hack.Slice(val.GetMysqlSet().String()), alloc)...)
 		case types.KindMysqlEnum:
+			if err = checkEnumValue(columns[i], val.GetMysqlEnum()); err != nil {
+				return data, errors.Trace(err)
+			}
 			data = append(data, dumpLengthEncodedString(hack.Slice(val.GetMysqlEnum().String()), alloc)...)
 		case types.KindBinaryLiteral, types.KindMysqlBit:
 			data = append(data, dumpLengthEncodedString(hack.Slice(val.GetBinaryLiteral().ToString()), alloc)...)
@@ -316,7 +380,18 @@ func dumpRowValuesBinary(alloc arena.Allocator, columns []*ColumnInfo, row []typ
 	return
 }
 
+// normalizeNegativeZero turns -0 into +0, MySQL never sends a negative zero.
+func normalizeNegativeZero(f float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	return f
+}
+
 func dumpTextValue(colInfo *ColumnInfo, value types.Datum) ([]byte, error) {
+	if colInfo == nil {
+		return nil, errors.Trace(errEmptyColumnInfo)
+	}
 	switch value.Kind() {
 	case types.KindInt64:
 		return strconv.AppendInt(nil, value.GetInt64(), 10), nil
@@ -327,22 +402,27 @@ func dumpTextValue(colInfo *ColumnInfo, value types.Datum) ([]byte, error) {
 		if colInfo.Decimal > 0 && int(colInfo.Decimal) != mysql.NotFixedDec {
 			prec = int(colInfo.Decimal)
 		}
-		return strconv.AppendFloat(nil, value.GetFloat64(), 'f', prec, 32), nil
+		return strconv.AppendFloat(nil, normalizeNegativeZero(value.GetFloat64()), 'f', prec, 32), nil
 	case types.KindFloat64:
 		prec := -1
 		if colInfo.Decimal > 0 && int(colInfo.Decimal) != mysql.NotFixedDec {
 			prec = int(colInfo.Decimal)
 		}
-		return strconv.AppendFloat(nil, value.GetFloat64(), 'f', prec, 64), nil
+		return strconv.AppendFloat(nil, normalizeNegativeZero(value.GetFloat64()), 'f', prec, 64), nil
 	case types.KindString, types.KindBytes:
+		// Result values are raw bytes, SQL escaping never applies here.
 		return value.GetBytes(), nil
 	case types.KindMysqlTime:
+		// A TIMESTAMP is already in the session time zone, it is converted when decoded from storage.
 		return hack.Slice(value.GetMysqlTime().String()), nil
 	case types.KindMysqlDuration:
 		return hack.Slice(value.GetMysqlDuration().String()), nil
 	case types.KindMysqlDecimal:
 		return hack.Slice(value.GetMysqlDecimal().String()), nil
 	case types.KindMysqlEnum:
+		if err := checkEnumValue(colInfo, value.GetMysqlEnum()); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return hack.Slice(value.GetMysqlEnum().String()), nil
 	case types.KindMysqlSet:
 		return hack.Slice(value.GetMysqlSet().String()), nil
@@ -354,3 +434,79 @@ func dumpTextValue(colInfo *ColumnInfo, value types.Datum) ([]byte, error) {
 		return nil, errInvalidType.Gen("invalid type %v", value.Kind())
 	}
 }
+
+// checkEnumValue makes sure the index of an enum value is within the declared elements of the column,
+// an out-of-range index means the value is corrupted. Index 0 is the empty string error value.
+func checkEnumValue(colInfo *ColumnInfo, e types.Enum) error {
+	if len(colInfo.Elems) > 0 && e.Value > uint64(len(colInfo.Elems)) {
+		return errInvalidType.Gen("enum index %d out of range, column has %d elements", e.Value, len(colInfo.Elems))
+	}
+	return nil
+}
+
+// dumpShowWarnings builds the rows of SHOW WARNINGS, the columns are Level, Code and Message.
+// The statement context does not keep the level of a warning, so the level is always "Warning".
+func dumpShowWarnings(warnings []error) [][]types.Datum {
+	rows := make([][]types.Datum, 0, len(warnings))
+	for _, warn := range warnings {
+		m := toSQLError(warn)
+		rows = append(rows, types.MakeDatums("Warning", int64(m.Code), m.Message))
+	}
+	return rows
+}
+
+// dumpWarningsResultSet dumps the complete text protocol result set of SHOW WARNINGS,
+// including the packet headers, the sequence of the first packet is 1.
+func dumpWarningsResultSet(alloc arena.Allocator, warnings []error, capability uint32, status uint16) ([]byte, error) {
+	columns := []*ColumnInfo{
+		{Name: "Level", OrgName: "Level", Charset: mysql.DefaultCollationID, ColumnLength: 64, Type: mysql.TypeVarString, Decimal: mysql.NotFixedDec},
+		{Name: "Code", OrgName: "Code", Charset: mysql.BinaryCollationID, ColumnLength: 4, Type: mysql.TypeLong,
+			Flag: uint16(mysql.NotNullFlag | mysql.UnsignedFlag)},
+		{Name: "Message", OrgName: "Message", Charset: mysql.DefaultCollationID, ColumnLength: 512, Type: mysql.TypeVarString, Decimal: mysql.NotFixedDec},
+	}
+
+	var buf bytes.Buffer
+	pkt := &packetIO{bufWriter: bufio.NewWriter(&buf), sequence: 1}
+	writePayload := func(payload []byte) error {
+		data := make([]byte, 4, 4+len(payload))
+		data = append(data, payload...)
+		return errors.Trace(pkt.writePacket(data))
+	}
+	eof := []byte{mysql.EOFHeader}
+	if capability&mysql.ClientProtocol41 > 0 {
+		eof = append(eof, 0, 0)
+		eof = append(eof, dumpUint16(status)...)
+	}
+
+	if err := writePayload(dumpLengthEncodedInt(uint64(len(columns)))); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, column := range columns {
+		if err := writePayload(column.Dump(alloc)); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := writePayload(eof); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, row := range dumpShowWarnings(warnings) {
+		var rowData []byte
+		for i, value := range row {
+			valData, err := dumpTextValue(columns[i], value)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			rowData = append(rowData, dumpLengthEncodedString(valData, alloc)...)
+		}
+		if err := writePayload(rowData); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := writePayload(eof); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := pkt.flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -14,10 +14,18 @@
 package server
 
 import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/terror"
+	"github.com/pingcap/tidb/util/arena"
 	"github.com/pingcap/tidb/util/testleak"
 	"github.com/pingcap/tidb/util/types"
+	"github.com/pingcap/tidb/util/types/json"
 )
 
 var _ = Suite(&testUtilSuite{})
@@ -29,18 +37,18 @@ func (s *testUtilSuite) TestDumpBinaryTime(c *C) {
 	defer testleak.AfterTest(c)()
 	t, err := types.ParseTimestamp("0000-00-00 00:00:00.0000000")
 	c.Assert(err, IsNil)
-	d, err := dumpBinaryDateTime(t, nil)
+	d, err := dumpBinaryDateTime(t)
 	c.Assert(err, IsNil)
 	c.Assert(d, DeepEquals, []byte{11, 1, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0})
 	t, err = types.ParseDatetime("0000-00-00 00:00:00.0000000")
 	c.Assert(err, IsNil)
-	d, err = dumpBinaryDateTime(t, nil)
+	d, err = dumpBinaryDateTime(t)
 	c.Assert(err, IsNil)
 	c.Assert(d, DeepEquals, []byte{11, 1, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0})
 
 	t, err = types.ParseDate("0000-00-00")
 	c.Assert(err, IsNil)
-	d, err = dumpBinaryDateTime(t, nil)
+	d, err = dumpBinaryDateTime(t)
 	c.Assert(err, IsNil)
 	c.Assert(d, DeepEquals, []byte{4, 1, 0, 1, 1})
 
@@ -50,6 +58,21 @@ func (s *testUtilSuite) TestDumpBinaryTime(c *C) {
 	c.Assert(d, DeepEquals, []byte{0})
 }
 
+func (s *testUtilSuite) TestDumpBinaryTimeSmallestNegative(c *C) {
+	defer testleak.AfterTest(c)()
+	// -00:00:00.000001 must keep the sign byte and use the 12-byte form.
+	d := dumpBinaryTime(-time.Microsecond)
+	c.Assert(d, DeepEquals, []byte{12, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0})
+
+	dur, n, err := parseBinaryDuration(d)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(d))
+	c.Assert(dur, Equals, -time.Microsecond)
+
+	_, _, err = parseBinaryDuration(d[:5])
+	c.Assert(err, Equals, mysql.ErrMalformPacket)
+}
+
 func (s *testUtilSuite) TestDumpTextValue(c *C) {
 	defer testleak.AfterTest(c)()
 
@@ -123,3 +146,400 @@ func (s *testUtilSuite) TestDumpTextValue(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(string(bs), Equals, "1.23")
 }
+
+func (s *testUtilSuite) TestDumpPositiveDecimal(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// MySQL never prefixes a positive decimal with '+', even if the value was
+	// written with an explicit sign.
+	colInfo := &ColumnInfo{
+		Type:    mysql.TypeNewDecimal,
+		Decimal: 2,
+	}
+	for _, str := range []string{"123.45", "+123.45"} {
+		var d types.Datum
+		d.SetMysqlDecimal(types.NewDecFromStringForTest(str))
+		bs, err := dumpTextValue(colInfo, d)
+		c.Assert(err, IsNil)
+		c.Assert(string(bs), Equals, "123.45")
+
+		bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+		c.Assert(err, IsNil)
+		// OK header, null bitmap, then the length-encoded string.
+		c.Assert(bs[2:], DeepEquals, append([]byte{6}, "123.45"...))
+	}
+}
+
+func (s *testUtilSuite) TestDumpBinaryFloatTypeMismatch(c *C) {
+	defer testleak.AfterTest(c)()
+
+	columns := []*ColumnInfo{{Type: mysql.TypeDouble}}
+	row := []types.Datum{types.NewFloat64Datum(1.5)}
+	bs, err := dumpRowValuesBinary(arena.StdAllocator, columns, row, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, dumpUint64(math.Float64bits(1.5)))
+
+	columns[0].Type = mysql.TypeFloat
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, columns, row, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, dumpUint32(math.Float32bits(1.5)))
+
+	columns[0].Type = mysql.TypeLonglong
+	_, err = dumpRowValuesBinary(arena.StdAllocator, columns, row, 0)
+	c.Assert(err, NotNil)
+	c.Assert(terror.ErrorEqual(err, errInvalidType), IsTrue)
+}
+
+func (s *testUtilSuite) TestDumpJSONNumberPrecision(c *C) {
+	defer testleak.AfterTest(c)()
+
+	j, err := json.ParseFromString(`{"a": 9223372036854775807, "b": 0.1234567890123456}`)
+	c.Assert(err, IsNil)
+	var d types.Datum
+	d.SetMysqlJSON(j)
+	bs, err := dumpTextValue(&ColumnInfo{Type: mysql.TypeJSON}, d)
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, `{"a":9223372036854775807,"b":0.1234567890123456}`)
+
+	// A number with more significant digits than a float64 holds is rounded like MySQL does.
+	j, err = json.ParseFromString(`[0.12345678901234567890123]`)
+	c.Assert(err, IsNil)
+	d.SetMysqlJSON(j)
+	bs, err = dumpTextValue(&ColumnInfo{Type: mysql.TypeJSON}, d)
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, `[0.12345678901234568]`)
+}
+
+func (s *testUtilSuite) TestDumpZeroBit(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// A zero BIT(M) value keeps all of its (M+7)/8 bytes, it is not an empty string.
+	tests := []struct {
+		flen     uint32
+		expected []byte
+	}{
+		{8, []byte{1, 0x00}},
+		{16, []byte{2, 0x00, 0x00}},
+	}
+	for _, t := range tests {
+		colInfo := &ColumnInfo{Type: mysql.TypeBit, ColumnLength: t.flen}
+		d := types.NewMysqlBitDatum(types.NewBinaryLiteralFromUint(0, int(t.flen+7)/8))
+		bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+		c.Assert(err, IsNil)
+		c.Assert(bs[2:], DeepEquals, t.expected)
+
+		bs, err = dumpTextValue(colInfo, d)
+		c.Assert(err, IsNil)
+		c.Assert(bs, DeepEquals, t.expected[1:])
+	}
+}
+
+func (s *testUtilSuite) TestDumpBinaryTimestampAcrossDST(c *C) {
+	defer testleak.AfterTest(c)()
+
+	loc, err := time.LoadLocation("America/New_York")
+	c.Assert(err, IsNil)
+	// New York switched from EST (-05:00) to EDT (-04:00) at 2017-03-12 07:00:00 UTC.
+	tests := []struct {
+		instant  time.Time
+		expected []byte
+	}{
+		{time.Date(2017, 3, 12, 6, 59, 59, 0, time.UTC), []byte{11, 0xe1, 0x07, 3, 12, 1, 59, 59, 0, 0, 0, 0}},
+		{time.Date(2017, 3, 12, 7, 0, 0, 0, time.UTC), []byte{11, 0xe1, 0x07, 3, 12, 3, 0, 0, 0, 0, 0, 0}},
+	}
+	colInfo := &ColumnInfo{Type: mysql.TypeTimestamp}
+	for _, t := range tests {
+		// A TIMESTAMP is stored in UTC and converted to the session time zone when decoded.
+		ts := types.Time{
+			Time: types.FromGoTime(t.instant),
+			Type: mysql.TypeTimestamp,
+		}
+		c.Assert(ts.ConvertTimeZone(time.UTC, loc), IsNil)
+		bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{types.NewDatum(ts)}, 0)
+		c.Assert(err, IsNil)
+		c.Assert(bs[2:], DeepEquals, t.expected)
+	}
+}
+
+func (s *testUtilSuite) TestDumpTextTimestampWithFspAndZone(c *C) {
+	defer testleak.AfterTest(c)()
+
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	c.Assert(err, IsNil)
+	// A TIMESTAMP is stored in UTC and converted to the session time zone when
+	// decoded, the dump path renders it as is.
+	ts := types.Time{
+		Time: types.FromGoTime(time.Date(2017, 1, 5, 15, 4, 5, 123000000, time.UTC)),
+		Type: mysql.TypeTimestamp,
+		Fsp:  3,
+	}
+	c.Assert(ts.ConvertTimeZone(time.UTC, loc), IsNil)
+	colInfo := &ColumnInfo{Type: mysql.TypeTimestamp, Decimal: 3}
+	bs, err := dumpTextValue(colInfo, types.NewDatum(ts))
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "2017-01-06 00:04:05.123")
+
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{types.NewDatum(ts)}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, []byte{11, 225, 7, 1, 6, 0, 4, 5, 0x78, 0xe0, 0x01, 0x00})
+}
+
+func (s *testUtilSuite) TestDumpDatumRepresentations(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// The same logical value must be dumped identically regardless of how the datum was built.
+	var reused types.Datum
+	reused.SetBytes([]byte("stale bytes"))
+	reused.SetInt64(-7)
+	var fromInt, fromInt64 types.Datum
+	fromInt.SetValue(-7)
+	fromInt64.SetValue(int64(-7))
+
+	var fromString, fromBytes, fromValue types.Datum
+	fromString.SetString("abc")
+	fromBytes.SetBytes([]byte("abc"))
+	fromValue.SetValue("abc")
+
+	tests := []struct {
+		colInfo *ColumnInfo
+		datums  []types.Datum
+	}{
+		{&ColumnInfo{Type: mysql.TypeLonglong}, []types.Datum{types.NewIntDatum(-7), reused, fromInt, fromInt64}},
+		{&ColumnInfo{Type: mysql.TypeVarString}, []types.Datum{types.NewStringDatum("abc"), fromString, fromBytes, fromValue}},
+	}
+	for _, t := range tests {
+		expectedBinary, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{t.colInfo}, t.datums[:1], 0)
+		c.Assert(err, IsNil)
+		expectedText, err := dumpTextValue(t.colInfo, t.datums[0])
+		c.Assert(err, IsNil)
+		for _, d := range t.datums[1:] {
+			bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{t.colInfo}, []types.Datum{d}, 0)
+			c.Assert(err, IsNil)
+			c.Assert(bs, DeepEquals, expectedBinary)
+			bs, err = dumpTextValue(t.colInfo, d)
+			c.Assert(err, IsNil)
+			c.Assert(bs, DeepEquals, expectedText)
+		}
+	}
+}
+
+func (s *testUtilSuite) TestDumpPackedDecimal(c *C) {
+	defer testleak.AfterTest(c)()
+
+	colInfo := &ColumnInfo{
+		Type:         mysql.TypeNewDecimal,
+		ColumnLength: 10,
+		Decimal:      4,
+	}
+	var d types.Datum
+	d.SetMysqlDecimal(types.NewDecFromStringForTest("-123456.7891"))
+	bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, binaryDumpPackedDecimal)
+	c.Assert(err, IsNil)
+
+	bin, isNull, _, err := parseLengthEncodedBytes(bs[2:])
+	c.Assert(err, IsNil)
+	c.Assert(isNull, IsFalse)
+	var dec types.MyDecimal
+	binSize, err := dec.FromBin(bin, 10, 4)
+	c.Assert(err, IsNil)
+	c.Assert(binSize, Equals, len(bin))
+	c.Assert(dec.String(), Equals, "-123456.7891")
+
+	// The protocol format is still used without the flag.
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, append([]byte{12}, "-123456.7891"...))
+
+	// An expression column has an unspecified length and scale.
+	colInfo = &ColumnInfo{
+		Type:    mysql.TypeNewDecimal,
+		Decimal: mysql.NotFixedDec,
+	}
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, binaryDumpPackedDecimal)
+	c.Assert(err, IsNil)
+	bin, _, _, err = parseLengthEncodedBytes(bs[2:])
+	c.Assert(err, IsNil)
+	precision, frac := d.GetMysqlDecimal().PrecisionAndFrac()
+	binSize, err = dec.FromBin(bin, precision, frac)
+	c.Assert(err, IsNil)
+	c.Assert(binSize, Equals, len(bin))
+	c.Assert(dec.String(), Equals, "-123456.7891")
+}
+
+func (s *testUtilSuite) TestDumpEnumOutOfRange(c *C) {
+	defer testleak.AfterTest(c)()
+
+	colInfo := &ColumnInfo{
+		Type:  mysql.TypeEnum,
+		Elems: []string{"a", "b"},
+	}
+	d := types.NewDatum(types.Enum{Name: "b", Value: 2})
+	bs, err := dumpTextValue(colInfo, d)
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "b")
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, []byte{1, 'b'})
+
+	d = types.NewDatum(types.Enum{Name: "", Value: 3})
+	_, err = dumpTextValue(colInfo, d)
+	c.Assert(terror.ErrorEqual(err, errInvalidType), IsTrue)
+	_, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+	c.Assert(terror.ErrorEqual(err, errInvalidType), IsTrue)
+}
+
+func (s *testUtilSuite) TestDumpWarningsResultSet(c *C) {
+	defer testleak.AfterTest(c)()
+
+	warnings := []error{
+		errors.New("first warning"),
+		mysql.NewErrf(mysql.ErrDataTooLong, "Data too long for column 'a' at row 1"),
+	}
+	data, err := dumpWarningsResultSet(arena.StdAllocator, warnings, mysql.ClientProtocol41, mysql.ServerStatusAutocommit)
+	c.Assert(err, IsNil)
+
+	packets := splitPackets(data)
+	for i := range packets {
+		c.Assert(data[3], Equals, uint8(i+1))
+		data = data[4+len(packets[i]):]
+	}
+	// column count, 3 column definitions, EOF, 2 rows, EOF.
+	c.Assert(packets, HasLen, 8)
+	c.Assert(packets[0], DeepEquals, []byte{3})
+	eof := []byte{mysql.EOFHeader, 0, 0, byte(mysql.ServerStatusAutocommit), 0}
+	c.Assert(packets[4], DeepEquals, eof)
+	c.Assert(packets[7], DeepEquals, eof)
+
+	expected := [][]string{
+		{"Warning", strconv.Itoa(mysql.ErrUnknown), "first warning"},
+		{"Warning", strconv.Itoa(mysql.ErrDataTooLong), "Data too long for column 'a' at row 1"},
+	}
+	for i, row := range packets[5:7] {
+		var values []string
+		for pos := 0; pos < len(row); {
+			v, _, n, err := parseLengthEncodedBytes(row[pos:])
+			c.Assert(err, IsNil)
+			values = append(values, string(v))
+			pos += n
+		}
+		c.Assert(values, DeepEquals, expected[i])
+	}
+}
+
+func (s *testUtilSuite) TestDumpTimeFsp0Rounding(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// Like DATETIME, a TIME value is rounded to the fsp of the column when it is
+	// created, the dump path writes the value as is.
+	colInfo := &ColumnInfo{Type: mysql.TypeDuration}
+	dur, err := types.ParseDuration("00:00:01.6", 0)
+	c.Assert(err, IsNil)
+	bs, err := dumpTextValue(colInfo, types.NewDatum(dur))
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "00:00:02")
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{types.NewDatum(dur)}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, []byte{8, 0, 0, 0, 0, 0, 0, 0, 2})
+
+	t, err := types.ParseTime("2017-01-05 00:00:01.6", mysql.TypeDatetime, 0)
+	c.Assert(err, IsNil)
+	bs, err = dumpTextValue(&ColumnInfo{Type: mysql.TypeDatetime}, types.NewDatum(t))
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "2017-01-05 00:00:02")
+}
+
+func (s *testUtilSuite) TestDumpBitAsBytes(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// A BIT value may arrive as raw bytes, it must be dumped the same as a
+	// BinaryLiteral, which is big-endian.
+	colInfo := &ColumnInfo{Type: mysql.TypeBit, ColumnLength: 16}
+	columns := []*ColumnInfo{colInfo}
+	bytesDatum := types.NewBytesDatum([]byte{0x01, 0x02})
+	bitDatum := types.NewMysqlBitDatum(types.NewBinaryLiteralFromUint(0x0102, 2))
+
+	bs, err := dumpRowValuesBinary(arena.StdAllocator, columns, []types.Datum{bytesDatum}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, []byte{2, 0x01, 0x02})
+	expected, err := dumpRowValuesBinary(arena.StdAllocator, columns, []types.Datum{bitDatum}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs, DeepEquals, expected)
+
+	bs, err = dumpTextValue(colInfo, bytesDatum)
+	c.Assert(err, IsNil)
+	expected, err = dumpTextValue(colInfo, bitDatum)
+	c.Assert(err, IsNil)
+	c.Assert(bs, DeepEquals, expected)
+}
+
+func (s *testUtilSuite) TestDumpNilColumn(c *C) {
+	defer testleak.AfterTest(c)()
+
+	_, err := dumpTextValue(nil, types.NewIntDatum(1))
+	c.Assert(terror.ErrorEqual(err, errEmptyColumnInfo), IsTrue)
+	_, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{{Type: mysql.TypeLonglong}, nil},
+		[]types.Datum{types.NewIntDatum(1), types.NewIntDatum(2)}, 0)
+	c.Assert(terror.ErrorEqual(err, errEmptyColumnInfo), IsTrue)
+}
+
+func (s *testUtilSuite) TestDumpEmbeddedNullBytes(c *C) {
+	defer testleak.AfterTest(c)()
+
+	value := []byte{'a', 0x00, 'b', 0x00}
+	colInfo := &ColumnInfo{Type: mysql.TypeVarString, Charset: mysql.BinaryCollationID}
+	d := types.NewBytesDatum(value)
+
+	bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{d}, 0)
+	c.Assert(err, IsNil)
+	parsed, isNull, n, err := parseLengthEncodedBytes(bs[2:])
+	c.Assert(err, IsNil)
+	c.Assert(isNull, IsFalse)
+	c.Assert(n, Equals, len(bs)-2)
+	c.Assert(parsed, DeepEquals, value)
+
+	bs, err = dumpTextValue(colInfo, d)
+	c.Assert(err, IsNil)
+	parsed, _, _, err = parseLengthEncodedBytes(dumpLengthEncodedString(bs, arena.StdAllocator))
+	c.Assert(err, IsNil)
+	c.Assert(parsed, DeepEquals, value)
+}
+
+func (s *testUtilSuite) TestDumpNegativeZero(c *C) {
+	defer testleak.AfterTest(c)()
+
+	negZero := math.Copysign(0, -1)
+	colInfo := &ColumnInfo{Type: mysql.TypeDouble, Decimal: mysql.NotFixedDec}
+	bs, err := dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{types.NewFloat64Datum(negZero)}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, dumpUint64(math.Float64bits(0)))
+	bs, err = dumpTextValue(colInfo, types.NewFloat64Datum(negZero))
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "0")
+
+	colInfo = &ColumnInfo{Type: mysql.TypeFloat, Decimal: mysql.NotFixedDec}
+	bs, err = dumpRowValuesBinary(arena.StdAllocator, []*ColumnInfo{colInfo}, []types.Datum{types.NewFloat32Datum(float32(negZero))}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(bs[2:], DeepEquals, dumpUint32(math.Float32bits(0)))
+	bs, err = dumpTextValue(colInfo, types.NewFloat32Datum(float32(negZero)))
+	c.Assert(err, IsNil)
+	c.Assert(string(bs), Equals, "0")
+}
+
+func (s *testUtilSuite) TestDumpTextValueUnescaped(c *C) {
+	defer testleak.AfterTest(c)()
+
+	value := `a\b'c"d`
+	colInfo := &ColumnInfo{Type: mysql.TypeVarString}
+	bs, err := dumpTextValue(colInfo, types.NewStringDatum(value))
+	c.Assert(err, IsNil)
+	c.Assert(bs, DeepEquals, []byte(value))
+}
+
+func (s *testUtilSuite) TestDumpBinaryTimeMax(c *C) {
+	defer testleak.AfterTest(c)()
+
+	// 838:59:59 is 34 days, 22 hours, 59 minutes and 59 seconds.
+	dur := 838*time.Hour + 59*time.Minute + 59*time.Second
+	c.Assert(dumpBinaryTime(dur), DeepEquals, []byte{8, 0, 34, 0, 0, 0, 22, 59, 59})
+	c.Assert(dumpBinaryTime(-dur), DeepEquals, []byte{8, 1, 34, 0, 0, 0, 22, 59, 59})
+}
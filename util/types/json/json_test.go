@@ -45,6 +45,16 @@ func (s *testJSONSuite) TestParseFromString(c *C) {
 	c.Assert(jstr2, Equals, `{"a":[1,"2",{"aa":"bb"},4,null],"b":true,"c":null}`)
 }
 
+func (s *testJSONSuite) TestParseNumberPrecision(c *C) {
+	jstr1 := `[9223372036854775807, -9223372036854775808, 18446744073709551615, 3.141592653589793]`
+	j := mustParseFromString(jstr1)
+	c.Assert(j.Array[0].TypeCode, Equals, TypeCodeInt64)
+	c.Assert(j.Array[1].TypeCode, Equals, TypeCodeInt64)
+	c.Assert(j.Array[2].TypeCode, Equals, TypeCodeUint64)
+	c.Assert(j.Array[3].TypeCode, Equals, TypeCodeFloat64)
+	c.Assert(j.String(), Equals, `[9223372036854775807,-9223372036854775808,18446744073709551615,3.141592653589793]`)
+}
+
 func (s *testJSONSuite) TestSerializeAndDeserialize(c *C) {
 	var jsonNilValue = CreateJSON(nil)
 	var jsonBoolValue = CreateJSON(true)
@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"unsafe"
 
 	"github.com/juju/errors"
@@ -47,7 +48,13 @@ func normalize(in interface{}) (j JSON, err error) {
 		if i64, errTp := t.Int64(); errTp == nil {
 			j.TypeCode = TypeCodeInt64
 			j.I64 = i64
+		} else if u64, errTp := strconv.ParseUint(string(t), 10, 64); errTp == nil {
+			// Integers beyond int64 must not lose precision as float64.
+			j.TypeCode = TypeCodeUint64
+			j.I64 = *(*int64)(unsafe.Pointer(&u64))
 		} else {
+			// Like MySQL, any other number is a DOUBLE, digits beyond the
+			// precision of float64 are rounded.
 			f64, _ := t.Float64()
 			j.TypeCode = TypeCodeFloat64
 			*(*float64)(unsafe.Pointer(&j.I64)) = f64